@@ -0,0 +1,180 @@
+// +build linux
+
+// Command k8s-connection-monitor runs the connection monitor against the
+// node it is deployed on, serving a Prometheus /metrics endpoint. It relies
+// on monitor.NetNamespaceInode and monitor.NetlinkInterfaces, which are
+// linux-only.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	monitor "github.com/bakins/k8s-connection-monitor"
+	"github.com/bakins/k8s-connection-monitor/pkg/connection"
+	"github.com/bakins/k8s-connection-monitor/pkg/cri"
+	"github.com/bakins/k8s-connection-monitor/pkg/moby"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+func main() {
+	var (
+		listenAddress = flag.String("listen-address", ":9273", "address to serve /metrics on")
+		interval      = flag.Duration("interval", 15*time.Second, "collection interval")
+		criSocket     = flag.String("cri-socket", "", "CRI runtime socket, eg /run/containerd/containerd.sock or /var/run/crio/crio.sock. If unset, the moby/docker client is used instead")
+	)
+	flag.Parse()
+
+	if err := run(*listenAddress, *interval, *criSocket); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(listenAddress string, interval time.Duration, criSocket string) error {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return errors.Wrap(err, "failed to create logger")
+	}
+
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		return errors.New("NODE_NAME environment variable must be set")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to load in-cluster config")
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create kubernetes client")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	podLister, err := newInformerPodLister(ctx, clientset, nodeName)
+	if err != nil {
+		return errors.Wrap(err, "failed to start pod informer")
+	}
+
+	var pidGetter monitor.PidGetter
+	if criSocket != "" {
+		pidGetter, err = cri.New(criSocket)
+	} else {
+		pidGetter, err = moby.New()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to create pid getter")
+	}
+
+	connectionGetter := connection.New(connection.WithPIDCorrelation())
+
+	m, err := monitor.New(podLister, pidGetter, connectionGetter,
+		monitor.WithLogger(logger),
+		monitor.WithNodeName(nodeName),
+		monitor.WithNetNamespaceResolver(monitor.NetNamespaceInode),
+		monitor.WithInterfaceResolver(monitor.NetlinkInterfaces),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to create monitor")
+	}
+
+	exporter, err := monitor.NewExporter(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to create exporter")
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter.Handler())
+	server := &http.Server{Addr: listenAddress, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- exporter.Run(ctx, interval)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		cancel()
+		return errors.Wrap(err, "metrics server failed")
+	}
+
+	if err := <-runErr; err != nil && err != context.Canceled {
+		return errors.Wrap(err, "exporter run failed")
+	}
+
+	return nil
+}
+
+// informerPodLister implements monitor.PodLister from a client-go
+// SharedInformer, rather than issuing a List call against the API server
+// on every tick.
+type informerPodLister struct {
+	lister corelisters.PodLister
+}
+
+func newInformerPodLister(ctx context.Context, clientset kubernetes.Interface, nodeName string) (*informerPodLister, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		10*time.Minute,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}),
+	)
+
+	informer := factory.Core().V1().Pods()
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		return nil, errors.New("timed out waiting for pod informer cache sync")
+	}
+
+	return &informerPodLister{lister: informer.Lister()}, nil
+}
+
+// ListPods returns the pods in this lister's informer cache. node is
+// ignored: the informer is already scoped to a single node via a
+// spec.nodeName field selector.
+func (p *informerPodLister) ListPods(node string) ([]corev1.Pod, error) {
+	pods, err := p.lister.List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list pods")
+	}
+
+	out := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		out = append(out, *pod)
+	}
+
+	return out, nil
+}
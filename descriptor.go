@@ -0,0 +1,144 @@
+package monitor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DescriptorFunc renders a single field of a Connection. It is invoked once
+// per requested column for every row passed to Describe.
+//
+// This follows the descriptor pattern used by containers/psgo (as invoked
+// from libpod's container top): a descriptor is a name plus a function that
+// knows how to render it, rather than a fixed set of struct fields.
+type DescriptorFunc func(c *Connection) (string, error)
+
+// descriptors holds the built-in and user-registered descriptors, keyed by
+// name. "COMM" is handled specially by Describe instead of living here
+// directly, since its default implementation needs a Describe-scoped
+// ssOutput to memoize its `ss -tanp` fallback - see useBuiltinCOMM.
+var descriptors = map[string]DescriptorFunc{
+	"LOCAL_ADDR":  func(c *Connection) (string, error) { return c.LocalAddress, nil },
+	"REMOTE_ADDR": func(c *Connection) (string, error) { return c.RemoteAddess, nil },
+	"STATE":       func(c *Connection) (string, error) { return c.Status, nil },
+	"PID":         func(c *Connection) (string, error) { return strconv.Itoa(c.PID), nil },
+	"UID":         func(c *Connection) (string, error) { return strconv.FormatUint(c.UID, 10), nil },
+	"INODE":       func(c *Connection) (string, error) { return strconv.FormatUint(c.Inode, 10), nil },
+	"RX_QUEUE":    func(c *Connection) (string, error) { return strconv.FormatUint(c.RxQueue, 10), nil },
+	"TX_QUEUE":    func(c *Connection) (string, error) { return strconv.FormatUint(c.TxQueue, 10), nil },
+	"RETRANSMITS": func(c *Connection) (string, error) { return strconv.FormatUint(c.Retransmits, 10), nil },
+}
+
+// useBuiltinCOMM tracks whether "COMM" still uses the built-in descriptorCOMM,
+// which Describe constructs fresh each call so it can share its ssOutput
+// with the unregistered-descriptor fallback. RegisterDescriptor clears it
+// once "COMM" is overridden, since a replacement descriptor manages its own
+// fallback behaviour.
+var useBuiltinCOMM = true
+
+// RegisterDescriptor registers a named descriptor for use with Describe.
+// Registering a name that already exists - including a built-in - replaces
+// it, so callers can override the default rendering of a column.
+func RegisterDescriptor(name string, fn DescriptorFunc) {
+	descriptors[name] = fn
+	if name == "COMM" {
+		useBuiltinCOMM = false
+	}
+}
+
+// Describe renders conns as a table of the requested descriptor names: a
+// header row naming each column, and one row per connection. A name that is
+// not registered falls back to shelling out to `ss -tanp` and returning the
+// matching line verbatim, so operators can ask for a descriptor this
+// package doesn't know how to compute without patching it.
+func Describe(conns []Connection, names []string) ([]string, [][]string, error) {
+	ss := &ssOutput{}
+
+	fns := make([]DescriptorFunc, len(names))
+	for i, n := range names {
+		if n == "COMM" && useBuiltinCOMM {
+			fns[i] = descriptorCOMM(ss)
+			continue
+		}
+
+		fn, ok := descriptors[n]
+		if !ok {
+			fn = ss.fallback
+		}
+		fns[i] = fn
+	}
+
+	rows := make([][]string, 0, len(conns))
+	for i := range conns {
+		row := make([]string, len(names))
+		for j, fn := range fns {
+			v, err := fn(&conns[i])
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to render descriptor %q", names[j])
+			}
+			row[j] = v
+		}
+		rows = append(rows, row)
+	}
+
+	return names, rows, nil
+}
+
+// descriptorCOMM builds the DescriptorFunc for the built-in "COMM" column. It
+// reads /proc/<pid>/comm directly where possible, falling back to `ss -tanp`
+// - via the same Describe-scoped ss as the unregistered-descriptor fallback,
+// so a Describe call needing the fallback for many rows still forks `ss`
+// once, not once per row.
+func descriptorCOMM(ss *ssOutput) DescriptorFunc {
+	return func(c *Connection) (string, error) {
+		if c.PID == 0 {
+			return "", nil
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(c.PID), "comm"))
+		if err != nil {
+			return ss.fallback(c)
+		}
+
+		return strings.TrimSpace(string(contents)), nil
+	}
+}
+
+// ssOutput memoizes a single `ss -tanp` invocation so that a Describe call
+// asking for an unregistered descriptor across many connections forks `ss`
+// once, not once per row.
+type ssOutput struct {
+	once  sync.Once
+	lines [][]byte
+	err   error
+}
+
+func (s *ssOutput) fallback(c *Connection) (string, error) {
+	s.once.Do(func() {
+		out, err := exec.Command("ss", "-tanp").Output()
+		if err != nil {
+			s.err = errors.Wrap(err, "ss fallback failed")
+			return
+		}
+		s.lines = bytes.Split(out, []byte("\n"))
+	})
+
+	if s.err != nil {
+		return "", s.err
+	}
+
+	for _, line := range s.lines {
+		if bytes.Contains(line, []byte(c.LocalAddress)) {
+			return strings.TrimSpace(string(line)), nil
+		}
+	}
+
+	return "", nil
+}
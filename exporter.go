@@ -0,0 +1,233 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// EventKind describes whether a Connection was newly observed or has
+// disappeared since the previous Collect.
+type EventKind int
+
+const (
+	// EventOpened indicates a connection present in this Collect that was
+	// not present in the previous one.
+	EventOpened EventKind = iota
+	// EventClosed indicates a connection present in the previous Collect
+	// that is no longer present in this one.
+	EventClosed
+)
+
+// EventSink receives connection open/close events computed by Exporter
+// between successive Collect calls, so callers can also stream them into
+// their own logging or tracing pipeline.
+type EventSink func(pod Pod, conn Connection, kind EventKind)
+
+// ExporterOption is used for setting options on a new Exporter
+type ExporterOption func(*Exporter) error
+
+// WithEventSink returns an ExporterOption that registers sink to receive
+// connection open/close events computed between successive Collect calls.
+func WithEventSink(sink EventSink) ExporterOption {
+	return func(e *Exporter) error {
+		e.eventSink = sink
+		return nil
+	}
+}
+
+// Exporter runs Collect on an interval, diffs each snapshot against the
+// previous one to compute connection open/close events, and exposes the
+// result as Prometheus metrics.
+type Exporter struct {
+	monitor   *Monitor
+	logger    *zap.Logger
+	eventSink EventSink
+
+	connections     *prometheus.GaugeVec
+	opened          *prometheus.CounterVec
+	closed          *prometheus.CounterVec
+	udpFlows        *prometheus.GaugeVec
+	collectDuration prometheus.Histogram
+
+	previous map[Pod]map[string]Connection
+}
+
+// NewExporter creates an Exporter that collects from m
+func NewExporter(m *Monitor, options ...ExporterOption) (*Exporter, error) {
+	e := &Exporter{
+		monitor:  m,
+		logger:   m.logger,
+		previous: make(map[Pod]map[string]Connection),
+
+		connections: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pod_tcp_connections",
+			Help: "Number of TCP connections observed for a pod, by state and remote ip.",
+		}, []string{"pod", "namespace", "state", "remote_ip"}),
+
+		opened: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "pod_tcp_connections_opened_total",
+			Help: "Total number of TCP connections observed opening for a pod.",
+		}, []string{"pod", "namespace"}),
+
+		closed: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "pod_tcp_connections_closed_total",
+			Help: "Total number of TCP connections observed closing for a pod.",
+		}, []string{"pod", "namespace"}),
+
+		udpFlows: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pod_udp_flows",
+			Help: "Number of UDP flows observed for a pod, by remote ip.",
+		}, []string{"pod", "namespace", "remote_ip"}),
+
+		collectDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name: "pod_connection_collect_duration_seconds",
+			Help: "Time taken by a single Collect call.",
+		}),
+	}
+
+	for _, o := range options {
+		if err := o(e); err != nil {
+			return nil, errors.Wrap(err, "exporter options failed")
+		}
+	}
+
+	return e, nil
+}
+
+// Handler returns an http.Handler serving the Exporter's metrics, suitable
+// for mounting at /metrics.
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Run calls Collect on the given interval until ctx is done, updating
+// Prometheus metrics and emitting events to the configured EventSink after
+// each Collect. It collects once immediately before waiting out the first
+// interval.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.collectOnce(); err != nil {
+			e.logger.Warn("collect failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Exporter) collectOnce() error {
+	start := time.Now()
+	snapshot, err := e.monitor.Collect()
+	e.collectDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return errors.Wrap(err, "collect failed")
+	}
+
+	e.diff(snapshot)
+
+	previous := make(map[Pod]map[string]Connection, len(snapshot))
+	for pod, conns := range snapshot {
+		keyed := make(map[string]Connection, len(conns))
+		for _, c := range conns {
+			keyed[connectionKey(c)] = c
+		}
+		previous[pod] = keyed
+	}
+	e.previous = previous
+
+	return nil
+}
+
+// diff compares snapshot against the previous Collect, updating gauges and
+// counters and emitting EventSink events for connections that appeared or
+// disappeared.
+func (e *Exporter) diff(snapshot map[Pod][]Connection) {
+	e.connections.Reset()
+	e.udpFlows.Reset()
+
+	for pod, conns := range snapshot {
+		prev := e.previous[pod]
+		current := make(map[string]bool, len(conns))
+
+		tcpCounts := make(map[[2]string]int)
+		udpFlowCounts := make(map[string]int)
+
+		for _, c := range conns {
+			key := connectionKey(c)
+			current[key] = true
+
+			remoteIP, _, err := net.SplitHostPort(c.RemoteAddess)
+			if err != nil {
+				remoteIP = c.RemoteAddess
+			}
+
+			switch c.Type {
+			case "tcp":
+				tcpCounts[[2]string{c.Status, remoteIP}]++
+			case "udp":
+				udpFlowCounts[remoteIP]++
+			}
+
+			if _, ok := prev[key]; !ok {
+				if c.Type == "tcp" {
+					e.opened.WithLabelValues(pod.Name, pod.Namespace).Inc()
+				}
+				e.emit(pod, c, EventOpened)
+			}
+		}
+
+		for key, c := range prev {
+			if !current[key] {
+				if c.Type == "tcp" {
+					e.closed.WithLabelValues(pod.Name, pod.Namespace).Inc()
+				}
+				e.emit(pod, c, EventClosed)
+			}
+		}
+
+		for k, count := range tcpCounts {
+			e.connections.WithLabelValues(pod.Name, pod.Namespace, k[0], k[1]).Set(float64(count))
+		}
+
+		for remoteIP, count := range udpFlowCounts {
+			e.udpFlows.WithLabelValues(pod.Name, pod.Namespace, remoteIP).Set(float64(count))
+		}
+	}
+
+	// A pod can also disappear entirely between ticks (deleted, evicted, a
+	// rolling restart) rather than simply losing some connections. The loop
+	// above only visits pods present in snapshot, so walk whatever pods are
+	// left in e.previous and close out the rest of their history here.
+	for pod, prev := range e.previous {
+		if _, ok := snapshot[pod]; ok {
+			continue
+		}
+
+		for _, c := range prev {
+			if c.Type == "tcp" {
+				e.closed.WithLabelValues(pod.Name, pod.Namespace).Inc()
+			}
+			e.emit(pod, c, EventClosed)
+		}
+	}
+}
+
+func (e *Exporter) emit(pod Pod, c Connection, kind EventKind) {
+	if e.eventSink != nil {
+		e.eventSink(pod, c, kind)
+	}
+}
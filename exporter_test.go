@@ -0,0 +1,109 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestExporter() *Exporter {
+	return &Exporter{
+		previous: make(map[Pod]map[string]Connection),
+
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "test_pod_tcp_connections",
+		}, []string{"pod", "namespace", "state", "remote_ip"}),
+
+		opened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_pod_tcp_connections_opened_total",
+		}, []string{"pod", "namespace"}),
+
+		closed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_pod_tcp_connections_closed_total",
+		}, []string{"pod", "namespace"}),
+
+		udpFlows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "test_pod_udp_flows",
+		}, []string{"pod", "namespace", "remote_ip"}),
+	}
+}
+
+func TestExporterDiffEmitsOpenedEvent(t *testing.T) {
+	e := newTestExporter()
+
+	pod := Pod{Name: "web", Namespace: "default"}
+	conn := Connection{Family: "inet", Type: "tcp", LocalAddress: "10.0.0.1:80", RemoteAddess: "10.0.0.2:443", Status: "ESTABLISHED"}
+
+	var events []EventKind
+	e.eventSink = func(p Pod, c Connection, kind EventKind) {
+		events = append(events, kind)
+	}
+
+	e.diff(map[Pod][]Connection{pod: {conn}})
+
+	if len(events) != 1 || events[0] != EventOpened {
+		t.Fatalf("diff() events = %v, want [EventOpened]", events)
+	}
+}
+
+func TestExporterDiffEmitsClosedEvent(t *testing.T) {
+	e := newTestExporter()
+
+	pod := Pod{Name: "web", Namespace: "default"}
+	conn := Connection{Family: "inet", Type: "tcp", LocalAddress: "10.0.0.1:80", RemoteAddess: "10.0.0.2:443", Status: "ESTABLISHED"}
+
+	// seed previous state directly, as collectOnce would after a prior Collect
+	e.previous[pod] = map[string]Connection{connectionKey(conn): conn}
+
+	var events []EventKind
+	e.eventSink = func(p Pod, c Connection, kind EventKind) {
+		events = append(events, kind)
+	}
+
+	e.diff(map[Pod][]Connection{pod: {}})
+
+	if len(events) != 1 || events[0] != EventClosed {
+		t.Fatalf("diff() events = %v, want [EventClosed]", events)
+	}
+}
+
+func TestExporterDiffClosesDisappearedPod(t *testing.T) {
+	e := newTestExporter()
+
+	pod := Pod{Name: "web", Namespace: "default"}
+	conn := Connection{Family: "inet", Type: "tcp", LocalAddress: "10.0.0.1:80", RemoteAddess: "10.0.0.2:443", Status: "ESTABLISHED"}
+
+	e.previous[pod] = map[string]Connection{connectionKey(conn): conn}
+
+	var events []EventKind
+	e.eventSink = func(p Pod, c Connection, kind EventKind) {
+		events = append(events, kind)
+	}
+
+	// pod is entirely gone from the new snapshot, eg deleted between ticks
+	e.diff(map[Pod][]Connection{})
+
+	if len(events) != 1 || events[0] != EventClosed {
+		t.Fatalf("diff() events = %v, want [EventClosed] for a disappeared pod", events)
+	}
+}
+
+func TestExporterDiffNoChangeEmitsNothing(t *testing.T) {
+	e := newTestExporter()
+
+	pod := Pod{Name: "web", Namespace: "default"}
+	conn := Connection{Family: "inet", Type: "tcp", LocalAddress: "10.0.0.1:80", RemoteAddess: "10.0.0.2:443", Status: "ESTABLISHED"}
+
+	e.previous[pod] = map[string]Connection{connectionKey(conn): conn}
+
+	var events []EventKind
+	e.eventSink = func(p Pod, c Connection, kind EventKind) {
+		events = append(events, kind)
+	}
+
+	e.diff(map[Pod][]Connection{pod: {conn}})
+
+	if len(events) != 0 {
+		t.Fatalf("diff() events = %v, want none for an unchanged connection", events)
+	}
+}
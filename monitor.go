@@ -0,0 +1,441 @@
+// Package monitor provides the high level functionality for monitoring open
+// connections on a Kubernetes node
+package monitor
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/api/core/v1"
+)
+
+// PodLister implements functions for getting list of pods on a node
+type PodLister interface {
+	ListPods(node string) ([]v1.Pod, error)
+}
+
+// PidGetter gets pids of containers given a container ID.
+// This should be the pid in the host namespace as monitor
+// should run there.
+type PidGetter interface {
+	GetPids(id string) ([]int, error)
+}
+
+// ConnectionGetter gets connections info given every pid known to share one
+// network namespace. pids[0] is the representative pid Collect chose to
+// query - reading the socket table is namespace-wide and only needs doing
+// once - but any pid-owner correlation (eg matching a socket's inode
+// against a pid's open fd table) must consider every pid in pids, since
+// the socket can belong to any process in the shared namespace, not just
+// the representative one.
+type ConnectionGetter interface {
+	// both connections and errors can be returned
+	GetConnections(pids []int) ([]Connection, error)
+}
+
+// Connection represents a connection - could also be a listening socket
+type Connection struct {
+	Family       string
+	Type         string
+	LocalAddress string
+	RemoteAddess string
+	Status       string
+	// Inode is the socket inode, as found in /proc/<pid>/net/*. It is used
+	// to correlate a connection back to the pid that owns it.
+	Inode uint64
+	// PID is the pid that owns this connection, resolved by matching Inode
+	// against that pid's /proc/<pid>/fd/socket:[inode] entries. It is 0 if
+	// the owning pid could not be determined.
+	PID int
+	// UID is the uid of the owning socket, as reported by /proc/<pid>/net/*.
+	UID uint64
+	// RxQueue and TxQueue are the receive and transmit queue sizes, as
+	// reported by /proc/<pid>/net/*.
+	RxQueue uint64
+	TxQueue uint64
+	// Retransmits is the retransmit counter reported by /proc/<pid>/net/*.
+	// It is only meaningful for tcp connections.
+	Retransmits uint64
+	// InterfaceName is the pod network interface LocalAddress belongs to,
+	// eg "eth0" or, for a pod with a Multus-attached secondary network,
+	// "net1". It is empty unless an InterfaceResolver is configured.
+	InterfaceName string
+	// NetworkName is the logical network name for InterfaceName, taken from
+	// the pod's "k8s.v1.cni.cncf.io/network-status" annotation (eg
+	// "sriov-net1"). It is empty unless that annotation is present and
+	// InterfaceName was resolved.
+	NetworkName string
+	// Secondary is true when InterfaceName is a pod interface other than
+	// the primary "eth0", eg a Multus secondary interface.
+	Secondary bool
+}
+
+// TODO: track ip and port separately?
+
+// NetNamespaceResolver resolves a key identifying the network namespace a
+// pid is in, so Collect can avoid querying the same namespace more than
+// once when multiple pids share one - as containers in a pod sandbox do.
+type NetNamespaceResolver func(pid int) (string, error)
+
+// InterfaceResolver resolves the network interfaces configured in the
+// network namespace of pid, as a map of interface name to the addresses
+// configured on it. It is used to annotate a Connection with the pod
+// interface its LocalAddress belongs to, for pods with more than one
+// network interface (eg Multus/ovn4nfv style CNI chaining).
+type InterfaceResolver func(pid int) (map[string][]string, error)
+
+// networkStatusAnnotation is the annotation Multus writes to a pod recording
+// which logical network each attached interface belongs to.
+const networkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+
+// primaryInterfaceName is the pod interface name Kubernetes itself manages.
+// Anything else is assumed to be a secondary, CNI-chained interface.
+const primaryInterfaceName = "eth0"
+
+// Monitor wraps getting pods and their connections
+type Monitor struct {
+	podLister            PodLister
+	pidGetter            PidGetter
+	connectionGetter     ConnectionGetter
+	logger               *zap.Logger
+	nodeName             string
+	netNamespaceResolver NetNamespaceResolver
+	interfaceResolver    InterfaceResolver
+}
+
+// Option is used for setting options on a new Monitor
+type Option func(*Monitor) error
+
+// New creates a new Monitor
+func New(pl PodLister, pg PidGetter, cg ConnectionGetter, options ...Option) (*Monitor, error) {
+	m := &Monitor{
+		podLister:        pl,
+		pidGetter:        pg,
+		connectionGetter: cg,
+	}
+
+	for _, f := range options {
+		if err := f(m); err != nil {
+			return nil, errors.Wrap(err, "options failed")
+		}
+	}
+
+	if m.logger == nil {
+		l, err := zap.NewProduction()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create default logger")
+		}
+		m.logger = l
+	}
+
+	if m.nodeName == "" {
+		hostname, err := getFQDN()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get FQDN hostname")
+		}
+		m.nodeName = hostname
+	}
+
+	if m.netNamespaceResolver == nil {
+		// default: treat every pid as its own namespace, ie no deduping.
+		m.netNamespaceResolver = func(pid int) (string, error) {
+			return strconv.Itoa(pid), nil
+		}
+	}
+	// so all our logs will include nodename
+	m.logger = m.logger.With(zap.String("nodeName", m.nodeName))
+	return m, nil
+}
+
+// WithLogger returns an Option with sets the logger to use for the Monitor.
+// Note: the monitor creates a sub logger for itself, so changes to the logger
+// will not be reflected in the monitor.
+func WithLogger(logger *zap.Logger) Option {
+	return func(m *Monitor) error {
+		m.logger = logger
+		return nil
+	}
+}
+
+// WithNodeName returns an Option with sets the node name for the Monitor.
+// Default is fqdn hostname
+func WithNodeName(nodeName string) Option {
+	return func(m *Monitor) error {
+		m.nodeName = nodeName
+		return nil
+	}
+}
+
+// WithNetNamespaceResolver returns an Option which sets the function Collect
+// uses to dedupe pids that share a network namespace before calling the
+// ConnectionGetter. The default resolver treats every pid as its own
+// namespace, ie it does not dedupe. See NetNamespaceInode (linux only) for a
+// resolver based on /proc/<pid>/ns/net.
+func WithNetNamespaceResolver(resolver NetNamespaceResolver) Option {
+	return func(m *Monitor) error {
+		m.netNamespaceResolver = resolver
+		return nil
+	}
+}
+
+// WithInterfaceResolver returns an Option which sets the function Collect
+// uses to annotate connections with the pod interface they belong to. If
+// unset, Connection.InterfaceName and Connection.NetworkName are left
+// empty. See NetlinkInterfaces (linux only) for a resolver based on netlink.
+func WithInterfaceResolver(resolver InterfaceResolver) Option {
+	return func(m *Monitor) error {
+		m.interfaceResolver = resolver
+		return nil
+	}
+}
+
+// Pod is a simplified pod for reporting.
+type Pod struct {
+	Name      string
+	Namespace string
+}
+
+// Collect gets running pods on the node, gets pids associated with those pods
+// and returns connections associated with them.
+// the
+func (m *Monitor) Collect() (map[Pod][]Connection, error) {
+	pods, err := m.podLister.ListPods(m.nodeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get pods for node %q", m.nodeName)
+	}
+
+	out := make(map[Pod][]Connection)
+
+	pods = runningPods(pods)
+
+	for _, p := range pods {
+		// get container id's for all running containers
+		ids := runningContainerIDs(p.Status)
+
+		pids := make(map[int]bool)
+		// get pids for each container. Note: we just log errors
+		// as the container could have exited, etc
+		for name, id := range ids {
+			containerPids, err := m.pidGetter.GetPids(id)
+			if err != nil {
+				m.logger.Warn(
+					"failed to get pids",
+					zap.String("name", p.ObjectMeta.Name),
+					zap.String("namespace", p.ObjectMeta.Namespace),
+					zap.String("container", name),
+					zap.String("containerID", id),
+				)
+				continue
+			}
+			for _, pid := range containerPids {
+				pids[pid] = true
+			}
+		}
+
+		// group pids that share a network namespace - eg sibling containers
+		// in the same pod sandbox - so we only query each namespace's
+		// socket table once, but still pass every pid in the group along
+		// for pid-owner correlation, since a socket in a shared namespace
+		// can belong to any of them, not just the representative pid.
+		seenNamespaces := make(map[string]int)
+		namespaceGroups := make([][]int, 0, len(pids))
+		for pid := range pids {
+			key, err := m.netNamespaceResolver(pid)
+			if err != nil {
+				// can't resolve the namespace - fall back to treating it as
+				// its own group rather than risk dropping it.
+				namespaceGroups = append(namespaceGroups, []int{pid})
+				continue
+			}
+			if i, ok := seenNamespaces[key]; ok {
+				namespaceGroups[i] = append(namespaceGroups[i], pid)
+				continue
+			}
+			seenNamespaces[key] = len(namespaceGroups)
+			namespaceGroups = append(namespaceGroups, []int{pid})
+		}
+
+		// get connections associated with each namespace group
+		connections := make(map[string]Connection)
+		for _, group := range namespaceGroups {
+			pid := group[0]
+
+			// just log error as process may have exited, etc.
+			// both connections and errors can be returned!
+			c, err := m.connectionGetter.GetConnections(group)
+			if err != nil {
+				m.logger.Warn(
+					"failed to get pids",
+					zap.String("name", p.ObjectMeta.Name),
+					zap.String("namespace", p.ObjectMeta.Namespace),
+					zap.Int("pid", pid),
+				)
+			}
+
+			if m.interfaceResolver != nil {
+				ifaces, err := m.interfaceResolver(pid)
+				if err != nil {
+					m.logger.Warn(
+						"failed to resolve interfaces",
+						zap.String("name", p.ObjectMeta.Name),
+						zap.String("namespace", p.ObjectMeta.Namespace),
+						zap.Int("pid", pid),
+					)
+				} else {
+					for i := range c {
+						c[i].InterfaceName = interfaceForAddress(ifaces, c[i].LocalAddress)
+						c[i].Secondary = c[i].InterfaceName != "" && c[i].InterfaceName != primaryInterfaceName
+					}
+				}
+			}
+
+			for _, i := range c {
+				// last one wins - it may not be the most recent status, however
+				connections[connectionKey(i)] = i
+			}
+		}
+
+		pod := Pod{
+			Name:      p.ObjectMeta.Name,
+			Namespace: p.ObjectMeta.Namespace,
+		}
+
+		networks := networkNamesByInterface(p.ObjectMeta.Annotations)
+
+		conns := make([]Connection, 0, len(connections))
+		for _, c := range connections {
+			if name, ok := networks[c.InterfaceName]; ok {
+				c.NetworkName = name
+			}
+			conns = append(conns, c)
+		}
+		out[pod] = conns
+	}
+
+	return out, nil
+}
+
+// interfaceForAddress returns the name of the interface in ifaces whose
+// address list contains the host portion of addr, or "" if none matches.
+func interfaceForAddress(ifaces map[string][]string, addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+
+	for name, addrs := range ifaces {
+		for _, a := range addrs {
+			if a == host {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// networkStatusEntry mirrors one element of the JSON array Multus writes to
+// the k8s.v1.cni.cncf.io/network-status pod annotation.
+type networkStatusEntry struct {
+	Name      string `json:"name"`
+	Interface string `json:"interface"`
+}
+
+// networkNamesByInterface parses the Multus network-status annotation, if
+// present, into a map of pod interface name (eg "net1") to logical network
+// name (eg "sriov-net1").
+func networkNamesByInterface(annotations map[string]string) map[string]string {
+	out := make(map[string]string)
+
+	raw, ok := annotations[networkStatusAnnotation]
+	if !ok {
+		return out
+	}
+
+	var entries []networkStatusEntry
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return out
+	}
+
+	for _, e := range entries {
+		if e.Interface != "" {
+			out[e.Interface] = e.Name
+		}
+	}
+
+	return out
+}
+
+// connectionKey returns a key identifying a Connection by its family, type,
+// local and remote address only. Status and the other per-observation fields
+// (queues, retransmits, pid, interface) are left out, since they may change
+// through a connection's lifetime and we want repeated observations of the
+// same connection to collapse to one.
+func connectionKey(c Connection) string {
+	return strings.Join([]string{c.Family, c.Type, c.LocalAddress, c.RemoteAddess}, "|")
+}
+
+func isContainerRunning(c v1.ContainerStatus) bool {
+	return c.State.Running != nil
+}
+
+func runningContainerIDs(in v1.PodStatus) map[string]string {
+	out := make(map[string]string)
+	// TODO: check init containers as well?
+	for _, c := range in.ContainerStatuses {
+		if isContainerRunning(c) {
+			if c.ContainerID != "" {
+				out[c.Name] = c.ContainerID
+			}
+		}
+	}
+	return out
+}
+
+func runningPods(in []v1.Pod) []v1.Pod {
+	out := make([]v1.Pod, 0, len(in))
+
+	for _, p := range in {
+		p := p
+		switch p.Status.Phase {
+		case v1.PodRunning, v1.PodPending:
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// based on https://github.com/Showmax/go-fqdn/blob/master/fqdn.go
+func getFQDN() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get hostname")
+	}
+
+	addrs, err := net.LookupIP(hostname)
+	if err != nil {
+		return hostname, nil
+	}
+
+	for _, addr := range addrs {
+		if ipv4 := addr.To4(); ipv4 != nil {
+			ip, err := ipv4.MarshalText()
+			if err != nil {
+				return hostname, nil
+			}
+			hosts, err := net.LookupAddr(string(ip))
+			if err != nil || len(hosts) == 0 {
+				return hostname, nil
+			}
+			fqdn := hosts[0]
+			return strings.TrimSuffix(fqdn, "."), nil
+		}
+	}
+	return hostname, nil
+}
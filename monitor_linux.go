@@ -0,0 +1,132 @@
+// +build linux
+
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// NetNamespaceInode is a NetNamespaceResolver that resolves the network
+// namespace of pid by reading the /proc/<pid>/ns/net symlink, eg
+// "net:[4026531992]". Pass it to WithNetNamespaceResolver to dedupe pids
+// that share a pod sandbox's network namespace.
+func NetNamespaceInode(pid int) (string, error) {
+	link, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read net namespace for pid %d", pid)
+	}
+
+	return link, nil
+}
+
+// NetlinkInterfaces is an InterfaceResolver that enters the network
+// namespace of pid and asks the kernel, via netlink RTM_GETADDR, which
+// addresses are configured on which interfaces. This is how a pod with a
+// Multus-attached secondary interface is told apart from one with only the
+// primary "eth0".
+func NetlinkInterfaces(pid int) (map[string][]string, error) {
+	var out map[string][]string
+
+	err := WithNetNamespace(pid, func() error {
+		m, err := dumpInterfaceAddresses()
+		out = m
+		return err
+	})
+
+	return out, err
+}
+
+// WithNetNamespace locks the calling goroutine to its OS thread and
+// switches that thread into the network namespace of pid, as reported
+// under the default "/proc" mount, for the duration of fn, restoring the
+// original namespace before returning.
+func WithNetNamespace(pid int, fn func() error) error {
+	return WithNetNamespaceRoot("/proc", pid, fn)
+}
+
+// WithNetNamespaceRoot is like WithNetNamespace, but reads the pid's
+// namespace symlink under root instead of the default "/proc" mount. It is
+// exported so other linux-only ConnectionGetter implementations (eg
+// pkg/inetdiag) that support pointing at an alternate /proc mount via
+// their own WithRoot option share this syscall plumbing rather than each
+// keeping their own copy.
+func WithNetNamespaceRoot(root string, pid int, fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		return errors.Wrap(err, "failed to open current net namespace")
+	}
+	defer origin.Close()
+
+	target, err := os.Open(fmt.Sprintf("%s/%d/ns/net", root, pid))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open net namespace for pid %d", pid)
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		return errors.Wrapf(err, "failed to enter net namespace of pid %d", pid)
+	}
+
+	// best effort restore - the thread we locked is unusable for anything
+	// else if this fails, which is why we locked it rather than the whole
+	// goroutine's future threads.
+	defer unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET) // nolint:errcheck
+
+	return fn()
+}
+
+// dumpInterfaceAddresses asks the kernel for every address configured in
+// the calling thread's current network namespace, returning a map of
+// interface name to its addresses.
+func dumpInterfaceAddresses() (map[string][]string, error) {
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "RTM_GETADDR failed")
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse netlink message")
+	}
+
+	out := make(map[string][]string)
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWADDR {
+			continue
+		}
+
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+
+		iface, err := net.InterfaceByIndex(int(ifa.Index))
+		if err != nil {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			continue
+		}
+
+		for _, a := range attrs {
+			if a.Attr.Type != syscall.IFA_ADDRESS {
+				continue
+			}
+
+			ip := net.IP(a.Value)
+			out[iface.Name] = append(out[iface.Name], ip.String())
+		}
+	}
+
+	return out, nil
+}
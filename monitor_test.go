@@ -0,0 +1,57 @@
+package monitor
+
+import "testing"
+
+func TestNetworkNamesByInterfaceNoAnnotation(t *testing.T) {
+	out := networkNamesByInterface(map[string]string{})
+	if len(out) != 0 {
+		t.Errorf("networkNamesByInterface() = %v, want empty map", out)
+	}
+}
+
+func TestNetworkNamesByInterfaceMultus(t *testing.T) {
+	annotations := map[string]string{
+		networkStatusAnnotation: `[
+			{"name":"k8s-pod-network","interface":"eth0"},
+			{"name":"sriov-net1","interface":"net1"}
+		]`,
+	}
+
+	out := networkNamesByInterface(annotations)
+
+	want := map[string]string{
+		"eth0": "k8s-pod-network",
+		"net1": "sriov-net1",
+	}
+
+	if len(out) != len(want) {
+		t.Fatalf("networkNamesByInterface() = %v, want %v", out, want)
+	}
+
+	for iface, name := range want {
+		if out[iface] != name {
+			t.Errorf("networkNamesByInterface()[%q] = %q, want %q", iface, out[iface], name)
+		}
+	}
+}
+
+func TestNetworkNamesByInterfaceInvalidJSON(t *testing.T) {
+	out := networkNamesByInterface(map[string]string{
+		networkStatusAnnotation: "not json",
+	})
+
+	if len(out) != 0 {
+		t.Errorf("networkNamesByInterface() = %v, want empty map for invalid JSON", out)
+	}
+}
+
+func TestNetworkNamesByInterfaceSkipsEmptyInterface(t *testing.T) {
+	annotations := map[string]string{
+		networkStatusAnnotation: `[{"name":"k8s-pod-network","interface":""}]`,
+	}
+
+	out := networkNamesByInterface(annotations)
+	if len(out) != 0 {
+		t.Errorf("networkNamesByInterface() = %v, want empty map for blank interface", out)
+	}
+}
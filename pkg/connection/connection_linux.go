@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"io/ioutil"
 	"net"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -18,6 +19,119 @@ import (
 
 // based on https://github.com/shirou/gopsutil/blob/master/net/net_linux.go
 
+// Option is used for setting options on a new Getter
+type Option func(*Getter)
+
+// WithRoot sets the root of the /proc filesystem to use. If unset, it
+// defaults to "/proc"
+func WithRoot(root string) Option {
+	return func(g *Getter) {
+		g.root = root
+	}
+}
+
+// WithPIDCorrelation enables attributing each Connection to the pid that
+// owns it. It does this by matching the inode of every socket found in the
+// namespace against the open file descriptors
+// (/proc/<pid>/fd/socket:[inode]) of every pid sharing that namespace, since
+// the socket can belong to any of them, not just the pid GetConnections used
+// to read the socket table. This is more expensive than a plain
+// GetConnections as it requires listing every pid's fd table, so it is
+// opt-in.
+func WithPIDCorrelation() Option {
+	return func(g *Getter) {
+		g.correlate = true
+	}
+}
+
+// Getter implements monitor.ConnectionGetter by reading /proc/<pid>/net/*
+type Getter struct {
+	root      string
+	correlate bool
+}
+
+// New creates a new Getter
+func New(options ...Option) *Getter {
+	g := &Getter{
+		root: "/proc",
+	}
+
+	for _, o := range options {
+		o(g)
+	}
+
+	return g
+}
+
+// GetConnections gets connections in the namespace shared by pids, reading
+// the socket table via pids[0] - the socket table is namespace-wide, so it
+// only needs reading once. If WithPIDCorrelation was set, Connection.PID is
+// populated for sockets owned by any pid in pids; otherwise it is left as 0.
+func (g *Getter) GetConnections(pids []int) ([]monitor.Connection, error) {
+	if len(pids) == 0 {
+		return nil, errors.New("no pids given")
+	}
+
+	out, err := GetConnections(g.root, pids[0])
+	if !g.correlate {
+		return out, err
+	}
+
+	owners, ownedErr := socketOwners(g.root, pids)
+	if ownedErr != nil {
+		// still return what we have - ownership is best effort
+		return out, multierror.Append(err, ownedErr).ErrorOrNil()
+	}
+
+	for i := range out {
+		if pid, ok := owners[out[i].Inode]; ok {
+			out[i].PID = pid
+		}
+	}
+
+	return out, err
+}
+
+// socketOwners returns, for every socket inode found open across pids, the
+// pid that owns it, by reading each pid's /proc/<pid>/fd/socket:[inode]
+// symlinks. A socket in a namespace shared by several pids can belong to
+// any one of them, so every pid sharing the namespace must be checked, not
+// just the one GetConnections used to read the socket table.
+func socketOwners(root string, pids []int) (map[uint64]int, error) {
+	out := make(map[uint64]int)
+	var result *multierror.Error
+
+	for _, pid := range pids {
+		dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "failed to read %q", dir))
+			continue
+		}
+
+		for _, e := range entries {
+			link, err := os.Readlink(filepath.Join(dir, e.Name()))
+			if err != nil {
+				// fd may have closed since we listed the directory
+				continue
+			}
+
+			if !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			out[inode] = pid
+		}
+	}
+
+	return out, result.ErrorOrNil()
+}
+
 // GetConnections gets connections in the namespace of the given pid.
 // root is the root of the /proc filesystem. If unset, it defaults to "/proc"
 func GetConnections(root string, pid int) ([]monitor.Connection, error) {
@@ -26,7 +140,7 @@ func GetConnections(root string, pid int) ([]monitor.Connection, error) {
 	}
 
 	var out []monitor.Connection
-	var result multierror.Error
+	var result *multierror.Error
 
 	for _, k := range connectionKindTypes {
 		filename := filepath.Join(root, strconv.Itoa(pid), k.filename)
@@ -42,7 +156,7 @@ func GetConnections(root string, pid int) ([]monitor.Connection, error) {
 
 	}
 
-	return out, result.NilOrError()
+	return out, result.ErrorOrNil()
 }
 
 func processINET(filename string, kind netConnectionKindType) ([]monitor.Connection, error) {
@@ -60,13 +174,13 @@ func processINET(filename string, kind netConnectionKindType) ([]monitor.Connect
 			continue
 		}
 
-		localAddress, err := decodeAddress(l[1])
+		localAddress, err := decodeAddress(kind.family, l[1])
 		if err != nil {
 			// just skip for now
 			continue
 		}
 
-		remoteAddess, err := decodeAddress(l[2])
+		remoteAddess, err := decodeAddress(kind.family, l[2])
 		if err != nil {
 			// just skip for now
 			continue
@@ -74,7 +188,7 @@ func processINET(filename string, kind netConnectionKindType) ([]monitor.Connect
 		var c monitor.Connection
 
 		c.Family = kind.family
-		c.Type = kind.Type
+		c.Type = kind.sockType
 		c.LocalAddress = localAddress
 		c.RemoteAddess = remoteAddess
 
@@ -88,24 +202,64 @@ func processINET(filename string, kind netConnectionKindType) ([]monitor.Connect
 		}
 		c.Status = status
 
+		if inode, err := strconv.ParseUint(l[9], 10, 64); err == nil {
+			c.Inode = inode
+		}
+
+		if uid, err := strconv.ParseUint(l[7], 10, 64); err == nil {
+			c.UID = uid
+		}
+
+		if tx, rx, err := decodeQueues(l[4]); err == nil {
+			c.TxQueue = tx
+			c.RxQueue = rx
+		}
+
+		if retransmits, err := strconv.ParseUint(l[6], 16, 64); err == nil {
+			c.Retransmits = retransmits
+		}
+
 		out = append(out, c)
 	}
 
 	return out, nil
 }
 
+// decodeQueues parses the tx_queue:rx_queue field of /proc/<pid>/net/tcp (and
+// udp), e.g. "00000150:00000000".
+func decodeQueues(s string) (tx uint64, rx uint64, err error) {
+	t := strings.Split(s, ":")
+	if len(t) != 2 {
+		return 0, 0, errors.Errorf("does not contain tx/rx queues %q", s)
+	}
+
+	tx, err = strconv.ParseUint(t[0], 16, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid tx_queue %q", t[0])
+	}
+
+	rx, err = strconv.ParseUint(t[1], 16, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid rx_queue %q", t[1])
+	}
+
+	return tx, rx, nil
+}
+
 type netConnectionKindType struct {
 	family   string
 	sockType string
 	filename string
 }
 
-// TODO: ipv6
+// TODO: unix sockets. /proc/net/unix has a different column layout (no
+// local/remote address:port) so it needs its own parser rather than
+// processINET.
 var connectionKindTypes = []netConnectionKindType{
 	{"inet", "tcp", "tcp"},
-	//{"inet6", "tcp", "tcp6"},
+	{"inet6", "tcp", "tcp6"},
 	{"inet", "udp", "udp"},
-	//{"inet6", "udp", "udp6"},
+	{"inet6", "udp", "udp6"},
 }
 
 var _TCPStatuses = map[string]string{
@@ -136,14 +290,37 @@ func decodeAddress(family string, src string) (string, error) {
 	if err != nil {
 		return "", errors.Wrapf(err, "decode error %q", addr)
 	}
+
 	var ip net.IP
-	// Assumes this is little_endian??
-	if family == "inet" {
+	switch family {
+	case "inet":
 		ip = net.IP(reverse(decoded))
+	case "inet6":
+		ip, err = decodeIPv6(decoded)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.Errorf("unknown family %q", family)
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.FormatInt(port, 10)), nil
+}
+
+// decodeIPv6 decodes a 16 byte IPv6 address as written by the kernel in
+// /proc/net/tcp6 and /proc/net/udp6: four 32-bit words, each in
+// little-endian byte order.
+func decodeIPv6(decoded []byte) (net.IP, error) {
+	if len(decoded) != net.IPv6len {
+		return nil, errors.Errorf("invalid ipv6 address length %d", len(decoded))
 	}
 
-	return ip.String() + ":" + strconv.Itoa(port)
+	ip := make(net.IP, net.IPv6len)
+	for word := 0; word < 4; word++ {
+		copy(ip[word*4:word*4+4], reverse(decoded[word*4:word*4+4]))
+	}
 
+	return ip, nil
 }
 
 func reverse(s []byte) []byte {
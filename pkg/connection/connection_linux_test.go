@@ -0,0 +1,84 @@
+// +build linux
+
+package connection
+
+import "testing"
+
+func TestDecodeAddressInet(t *testing.T) {
+	// 0100007F:0050 is 127.0.0.1:80 as written by the kernel to
+	// /proc/net/tcp: the address is 4 little-endian bytes, the port is
+	// big-endian.
+	got, err := decodeAddress("inet", "0100007F:0050")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "127.0.0.1:80"
+	if got != want {
+		t.Errorf("decodeAddress() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeAddressInet6(t *testing.T) {
+	// 00000000000000000000000001000000:0050 is ::1:80 as written to
+	// /proc/net/tcp6: four 32-bit words, each little-endian.
+	got, err := decodeAddress("inet6", "00000000000000000000000001000000:0050")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[::1]:80"
+	if got != want {
+		t.Errorf("decodeAddress() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeAddressMissingPort(t *testing.T) {
+	if _, err := decodeAddress("inet", "0100007F"); err == nil {
+		t.Error("expected error for address missing a port")
+	}
+}
+
+func TestDecodeIPv6(t *testing.T) {
+	// 2001:db8::1 encoded as four little-endian 32-bit words, as the kernel
+	// writes it to /proc/net/tcp6.
+	decoded := []byte{0xb8, 0x0d, 0x01, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0, 0, 0}
+
+	ip, err := decodeIPv6(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "2001:db8::1"
+	if ip.String() != want {
+		t.Errorf("decodeIPv6() = %q, want %q", ip.String(), want)
+	}
+}
+
+func TestDecodeIPv6InvalidLength(t *testing.T) {
+	if _, err := decodeIPv6([]byte{0x01, 0x02}); err == nil {
+		t.Error("expected error for short address")
+	}
+}
+
+func TestReverse(t *testing.T) {
+	got := reverse([]byte{1, 2, 3, 4})
+	want := []byte{4, 3, 2, 1}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("reverse() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDecodeQueues(t *testing.T) {
+	tx, rx, err := decodeQueues("00000150:00000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx != 0x150 || rx != 0x2 {
+		t.Errorf("decodeQueues() = (%d, %d), want (%d, %d)", tx, rx, 0x150, 0x2)
+	}
+}
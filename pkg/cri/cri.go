@@ -0,0 +1,157 @@
+// Package cri can get Pids from container ids when using a CRI-compatible
+// runtime such as containerd or CRI-O
+package cri
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Client wraps a CRI runtime service client
+type Client struct {
+	conn    *grpc.ClientConn
+	runtime runtimeapi.RuntimeServiceClient
+}
+
+// New creates a new client that talks to the CRI runtime socket at addr -
+// for example "/run/containerd/containerd.sock" or "/var/run/crio/crio.sock"
+func New(addr string) (*Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	conn, err := grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(dialer),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial %q", addr)
+	}
+
+	return &Client{
+		conn:    conn,
+		runtime: runtimeapi.NewRuntimeServiceClient(conn),
+	}, nil
+}
+
+func dialer(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", addr)
+}
+
+// Close closes the underlying connection to the CRI runtime
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+const (
+	containerdPrefix = "containerd://"
+	crioPrefix       = "cri-o://"
+)
+
+// GetPids returns all pids associated with a container
+func (c *Client) GetPids(id string) ([]int, error) {
+	trimmed, ok := trimCRIPrefix(id)
+	if !ok {
+		return nil, errors.Errorf("%q is not a CRI containerID", id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{
+		ContainerId: trimmed,
+		Verbose:     true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "ContainerStatus failed")
+	}
+
+	pid, err := initPid(resp.Info)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine init pid")
+	}
+
+	if pid <= 0 {
+		return nil, nil
+	}
+
+	pids := []int{pid}
+
+	children, err := childPids(pid)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get child pids")
+	}
+
+	return append(pids, children...), nil
+}
+
+func trimCRIPrefix(id string) (string, bool) {
+	for _, prefix := range []string{containerdPrefix, crioPrefix} {
+		if strings.HasPrefix(id, prefix) {
+			return strings.TrimPrefix(id, prefix), true
+		}
+	}
+	return "", false
+}
+
+// verboseInfo is the subset of the ContainerStatusResponse "info" verbose
+// data that containerd and CRI-O populate with the container's init pid.
+type verboseInfo struct {
+	Pid int `json:"pid"`
+}
+
+func initPid(info map[string]string) (int, error) {
+	raw, ok := info["info"]
+	if !ok {
+		return 0, errors.New("container status response did not include verbose info")
+	}
+
+	var v verboseInfo
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return 0, errors.Wrap(err, "failed to parse verbose info")
+	}
+
+	return v.Pid, nil
+}
+
+// childPids follows /proc/<pid>/task/*/children to find any additional pids
+// running under the container's init process, in case the runtime only
+// reports the init pid itself.
+func childPids(pid int) ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join("/proc", strconv.Itoa(pid), "task", "*", "children"))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []int
+	for _, m := range matches {
+		contents, err := ioutil.ReadFile(m)
+		if err != nil {
+			// task may have exited between the glob and the read
+			continue
+		}
+
+		for _, f := range strings.Fields(string(contents)) {
+			child, err := strconv.Atoi(f)
+			if err != nil {
+				continue
+			}
+			out = append(out, child)
+		}
+	}
+
+	return out, nil
+}
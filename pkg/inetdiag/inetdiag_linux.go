@@ -0,0 +1,425 @@
+// +build linux
+
+// Package inetdiag implements monitor.ConnectionGetter using the Linux
+// NETLINK_INET_DIAG (SOCK_DIAG) socket diagnostics interface, rather than
+// parsing /proc/<pid>/net/tcp as pkg/connection does. Reading the full
+// /proc socket table on every collection is O(pods x sockets); asking the
+// kernel directly via netlink is typically 10-50x faster and returns uid
+// and inode natively, so monitor.Connection.UID comes for free. PID still
+// requires the same /proc/<pid>/fd correlation pass that
+// connection.WithPIDCorrelation does, since inet_diag reports the inode a
+// socket belongs to but not the pid that opened it.
+package inetdiag
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	monitor "github.com/bakins/k8s-connection-monitor"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Getter implements monitor.ConnectionGetter via NETLINK_INET_DIAG.
+type Getter struct {
+	root string
+}
+
+// Option configures a Getter.
+type Option func(*Getter)
+
+// WithRoot sets the root of the /proc filesystem used to enter a pid's
+// network namespace. If unset, it defaults to "/proc".
+func WithRoot(root string) Option {
+	return func(g *Getter) {
+		g.root = root
+	}
+}
+
+// New creates a new Getter.
+func New(options ...Option) *Getter {
+	g := &Getter{
+		root: "/proc",
+	}
+
+	for _, o := range options {
+		o(g)
+	}
+
+	return g
+}
+
+// allStates asks the kernel for sockets in every TCP/UDP state - it is a
+// bitmask with every bit 0 through 31 set.
+const allStates = 0xffffffff
+
+type protocolKind struct {
+	protocol uint8
+	sockType string
+}
+
+var protocols = []protocolKind{
+	{unix.IPPROTO_TCP, "tcp"},
+	{unix.IPPROTO_UDP, "udp"},
+}
+
+var families = []struct {
+	family uint8
+	name   string
+}{
+	{unix.AF_INET, "inet"},
+	{unix.AF_INET6, "inet6"},
+}
+
+// GetConnections enters the network namespace shared by pids via pids[0]
+// and asks the kernel's socket diagnostics interface for every tcp/udp
+// socket in it, across both AF_INET and AF_INET6 - the socket table is
+// namespace-wide, so entering via any one pid in the namespace is enough.
+// Connection.PID is populated for sockets owned by any pid in pids, by
+// correlating each socket's inode against every one of those pids' open
+// file descriptors, same as connection.WithPIDCorrelation.
+func (g *Getter) GetConnections(pids []int) ([]monitor.Connection, error) {
+	if len(pids) == 0 {
+		return nil, errors.New("no pids given")
+	}
+
+	root := g.root
+	if root == "" {
+		root = "/proc"
+	}
+
+	var out []monitor.Connection
+
+	err := monitor.WithNetNamespaceRoot(root, pids[0], func() error {
+		for _, p := range protocols {
+			for _, f := range families {
+				conns, err := queryInetDiag(f.family, f.name, p)
+				if err != nil {
+					return errors.Wrapf(err, "inet_diag query failed for %s/%s", f.name, p.sockType)
+				}
+				out = append(out, conns...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return out, err
+	}
+
+	owners, ownedErr := socketOwners(root, pids)
+	if ownedErr != nil {
+		// still return what we have - ownership is best effort
+		return out, errors.Wrap(ownedErr, "failed to correlate sockets to pid")
+	}
+
+	for i := range out {
+		if pid, ok := owners[out[i].Inode]; ok {
+			out[i].PID = pid
+		}
+	}
+
+	return out, nil
+}
+
+// socketOwners returns, for every socket inode found open across pids, the
+// pid that owns it, by reading each pid's root/<pid>/fd/socket:[inode]
+// symlinks. A socket in a namespace shared by several pids can belong to
+// any one of them, so every pid sharing the namespace must be checked, not
+// just the one GetConnections used to enter the namespace.
+func socketOwners(root string, pids []int) (map[uint64]int, error) {
+	out := make(map[uint64]int)
+	var result *multierror.Error
+
+	for _, pid := range pids {
+		dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "failed to read %q", dir))
+			continue
+		}
+
+		for _, e := range entries {
+			link, err := os.Readlink(filepath.Join(dir, e.Name()))
+			if err != nil {
+				// fd may have closed since we listed the directory
+				continue
+			}
+
+			if !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			out[inode] = pid
+		}
+	}
+
+	return out, result.ErrorOrNil()
+}
+
+// inetDiagSockID mirrors struct inet_diag_sockid from linux/inet_diag.h
+type inetDiagSockID struct {
+	SPort  [2]byte
+	DPort  [2]byte
+	Src    [16]byte
+	Dst    [16]byte
+	If     uint32
+	Cookie [2]uint32
+}
+
+// inetDiagReqV2 mirrors struct inet_diag_req_v2 from linux/inet_diag.h
+type inetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       inetDiagSockID
+}
+
+// inetDiagMsg mirrors struct inet_diag_msg from linux/inet_diag.h
+type inetDiagMsg struct {
+	Family  uint8
+	State   uint8
+	Timer   uint8
+	Retrans uint8
+	ID      inetDiagSockID
+	Expires uint32
+	RQueue  uint32
+	WQueue  uint32
+	UID     uint32
+	Inode   uint32
+}
+
+const inetDiagMsgSize = 4 + 48 + 4 + 4 + 4 + 4 + 4
+
+func queryInetDiag(family uint8, familyName string, p protocolKind) ([]monitor.Connection, error) {
+	sock, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create netlink socket")
+	}
+	defer unix.Close(sock)
+
+	if err := unix.Bind(sock, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, errors.Wrap(err, "failed to bind netlink socket")
+	}
+
+	req := inetDiagReqV2{
+		Family:   family,
+		Protocol: p.protocol,
+		States:   allStates,
+	}
+
+	if err := sendRequest(sock, &req); err != nil {
+		return nil, errors.Wrap(err, "failed to send inet_diag_req_v2")
+	}
+
+	msgs, err := recvMessages(sock)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read inet_diag response")
+	}
+
+	out := make([]monitor.Connection, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, toConnection(familyName, p.sockType, m))
+	}
+
+	return out, nil
+}
+
+// SOCK_DIAG_BY_FAMILY is the netlink message type used for all inet_diag
+// requests and responses, defined in linux/sock_diag.h.
+const sockDiagByFamily = 20
+
+func sendRequest(sock int, req *inetDiagReqV2) error {
+	body := make([]byte, 4+4+4+4+inetDiagReqV2Size)
+
+	payload := marshalReq(req)
+
+	hdrLen := uint32(unix.NLMSG_HDRLEN + len(payload))
+	binary.LittleEndian.PutUint32(body[0:4], hdrLen)
+	binary.LittleEndian.PutUint16(body[4:6], sockDiagByFamily)
+	binary.LittleEndian.PutUint16(body[6:8], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	binary.LittleEndian.PutUint32(body[8:12], 1) // sequence number
+	binary.LittleEndian.PutUint32(body[12:16], 0)
+	copy(body[16:], payload)
+
+	return unix.Sendto(sock, body[:hdrLen], 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+const inetDiagReqV2Size = 1 + 1 + 1 + 1 + 4 + 48
+
+func marshalReq(req *inetDiagReqV2) []byte {
+	out := make([]byte, inetDiagReqV2Size)
+	out[0] = req.Family
+	out[1] = req.Protocol
+	out[2] = req.Ext
+	out[3] = req.Pad
+	binary.LittleEndian.PutUint32(out[4:8], req.States)
+	copy(out[8:10], req.ID.SPort[:])
+	copy(out[10:12], req.ID.DPort[:])
+	copy(out[12:28], req.ID.Src[:])
+	copy(out[28:44], req.ID.Dst[:])
+	binary.LittleEndian.PutUint32(out[44:48], req.ID.If)
+	binary.LittleEndian.PutUint32(out[48:52], req.ID.Cookie[0])
+	binary.LittleEndian.PutUint32(out[52:56], req.ID.Cookie[1])
+	return out
+}
+
+// recvBufPages sizes the buffer recvMessages reads each netlink datagram
+// into. A dump on a node with hundreds of sockets - the high pod-density
+// case this getter was added for - can fill more than one page per
+// recvfrom, so start well above a single page. If even that is too small,
+// recvMessages returns the truncation error from recvDatagram; it does not
+// retry the dump.
+const recvBufPages = 8
+
+func recvMessages(sock int) ([]inetDiagMsg, error) {
+	var out []inetDiagMsg
+
+	buf := make([]byte, recvBufPages*unix.Getpagesize())
+	for {
+		n, err := recvDatagram(sock, &buf)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse netlink message")
+		}
+
+		done := false
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				done = true
+			case syscall.NLMSG_ERROR:
+				return nil, errors.New("netlink returned NLMSG_ERROR")
+			default:
+				msg, err := unmarshalMsg(m.Data)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, msg)
+			}
+		}
+
+		if done {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// recvDatagram reads a single netlink datagram from sock into *buf. If the
+// kernel reports the datagram was truncated (MSG_TRUNC) - unlike recvfrom,
+// which would silently hand back only what fit - it grows *buf for any
+// later calls and returns an error rather than the partial, already
+// corrupted read: a datagram socket discards whatever didn't fit as soon as
+// it's read, so there is nothing left to retry for this one datagram, but
+// the caller learns connections were dropped instead of it happening
+// silently.
+func recvDatagram(sock int, buf *[]byte) (int, error) {
+	n, _, recvflags, _, err := unix.Recvmsg(sock, *buf, nil, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "recvmsg failed")
+	}
+
+	if recvflags&unix.MSG_TRUNC != 0 {
+		*buf = make([]byte, len(*buf)*2)
+		return 0, errors.Errorf("inet_diag response truncated, dropping connections from this read")
+	}
+
+	return n, nil
+}
+
+func unmarshalMsg(data []byte) (inetDiagMsg, error) {
+	var m inetDiagMsg
+
+	if len(data) < inetDiagMsgSize {
+		return m, errors.Errorf("short inet_diag_msg: %d bytes", len(data))
+	}
+
+	m.Family = data[0]
+	m.State = data[1]
+	m.Timer = data[2]
+	m.Retrans = data[3]
+	copy(m.ID.SPort[:], data[4:6])
+	copy(m.ID.DPort[:], data[6:8])
+	copy(m.ID.Src[:], data[8:24])
+	copy(m.ID.Dst[:], data[24:40])
+	m.ID.If = binary.LittleEndian.Uint32(data[40:44])
+	m.ID.Cookie[0] = binary.LittleEndian.Uint32(data[44:48])
+	m.ID.Cookie[1] = binary.LittleEndian.Uint32(data[48:52])
+	m.Expires = binary.LittleEndian.Uint32(data[52:56])
+	m.RQueue = binary.LittleEndian.Uint32(data[56:60])
+	m.WQueue = binary.LittleEndian.Uint32(data[60:64])
+	m.UID = binary.LittleEndian.Uint32(data[64:68])
+	m.Inode = binary.LittleEndian.Uint32(data[68:72])
+
+	return m, nil
+}
+
+var tcpStates = map[uint8]string{
+	1:  "ESTABLISHED",
+	2:  "SYN_SENT",
+	3:  "SYN_RECV",
+	4:  "FIN_WAIT1",
+	5:  "FIN_WAIT2",
+	6:  "TIME_WAIT",
+	7:  "CLOSE",
+	8:  "CLOSE_WAIT",
+	9:  "LAST_ACK",
+	10: "LISTEN",
+	11: "CLOSING",
+}
+
+func toConnection(family, sockType string, m inetDiagMsg) monitor.Connection {
+	status := ""
+	if sockType == "tcp" {
+		status = tcpStates[m.State]
+	}
+	if status == "" {
+		status = "NONE"
+	}
+
+	return monitor.Connection{
+		Family:       family,
+		Type:         sockType,
+		LocalAddress: addrString(family, m.ID.Src[:], m.ID.SPort),
+		RemoteAddess: addrString(family, m.ID.Dst[:], m.ID.DPort),
+		Status:       status,
+		Inode:        uint64(m.Inode),
+		UID:          uint64(m.UID),
+		RxQueue:      uint64(m.RQueue),
+		TxQueue:      uint64(m.WQueue),
+		Retransmits:  uint64(m.Retrans),
+	}
+}
+
+// addrString renders an inet_diag_sockid address/port pair. idiag_sport and
+// idiag_dport are big-endian in the raw message; idiag_src/idiag_dst are
+// already the raw address bytes (4 used for AF_INET, 16 for AF_INET6).
+func addrString(family string, addr []byte, port [2]byte) string {
+	ip := net.IP(addr)
+	if family == "inet" {
+		ip = net.IP(addr[:4])
+	}
+
+	portNum := int(port[0])<<8 | int(port[1])
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(portNum))
+}
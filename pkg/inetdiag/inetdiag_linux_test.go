@@ -0,0 +1,99 @@
+// +build linux
+
+package inetdiag
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMarshalReq(t *testing.T) {
+	req := &inetDiagReqV2{
+		Family:   2,
+		Protocol: 6,
+		States:   allStates,
+	}
+	req.ID.SPort = [2]byte{0x1f, 0x90} // 8080, big-endian
+	req.ID.If = 4
+
+	body := marshalReq(req)
+	if len(body) != inetDiagReqV2Size {
+		t.Fatalf("marshalReq() returned %d bytes, want %d", len(body), inetDiagReqV2Size)
+	}
+
+	if body[0] != req.Family || body[1] != req.Protocol {
+		t.Errorf("marshalReq() family/protocol = %d/%d, want %d/%d", body[0], body[1], req.Family, req.Protocol)
+	}
+
+	if body[8] != 0x1f || body[9] != 0x90 {
+		t.Errorf("marshalReq() sport = %x%x, want 1f90", body[8], body[9])
+	}
+}
+
+func TestUnmarshalMsg(t *testing.T) {
+	data := make([]byte, inetDiagMsgSize)
+	data[0] = 2  // AF_INET
+	data[1] = 10 // LISTEN
+	data[4] = 0x1f
+	data[5] = 0x90
+	copy(data[8:12], net.ParseIP("10.0.0.1").To4())
+	// inode, little-endian, at offset 68
+	data[68], data[69], data[70], data[71] = 0x2a, 0, 0, 0
+
+	m, err := unmarshalMsg(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Family != 2 || m.State != 10 {
+		t.Errorf("unmarshalMsg() family/state = %d/%d, want 2/10", m.Family, m.State)
+	}
+
+	if m.Inode != 0x2a {
+		t.Errorf("unmarshalMsg() inode = %d, want %d", m.Inode, 0x2a)
+	}
+}
+
+func TestUnmarshalMsgShort(t *testing.T) {
+	if _, err := unmarshalMsg(make([]byte, inetDiagMsgSize-1)); err == nil {
+		t.Error("expected error for short inet_diag_msg")
+	}
+}
+
+func TestToConnection(t *testing.T) {
+	var m inetDiagMsg
+	m.State = 1 // ESTABLISHED
+	copy(m.ID.Src[:], net.ParseIP("10.0.0.1").To4())
+	copy(m.ID.Dst[:], net.ParseIP("10.0.0.2").To4())
+	m.ID.SPort = [2]byte{0x1f, 0x90} // 8080
+	m.ID.DPort = [2]byte{0x00, 0x50} // 80
+	m.Inode = 42
+	m.UID = 1000
+
+	c := toConnection("inet", "tcp", m)
+
+	if c.Status != "ESTABLISHED" {
+		t.Errorf("toConnection() status = %q, want ESTABLISHED", c.Status)
+	}
+
+	if c.LocalAddress != "10.0.0.1:8080" {
+		t.Errorf("toConnection() local address = %q, want 10.0.0.1:8080", c.LocalAddress)
+	}
+
+	if c.RemoteAddess != "10.0.0.2:80" {
+		t.Errorf("toConnection() remote address = %q, want 10.0.0.2:80", c.RemoteAddess)
+	}
+
+	if c.Inode != 42 || c.UID != 1000 {
+		t.Errorf("toConnection() inode/uid = %d/%d, want 42/1000", c.Inode, c.UID)
+	}
+}
+
+func TestToConnectionUnknownUDPStatus(t *testing.T) {
+	var m inetDiagMsg
+	c := toConnection("inet", "udp", m)
+
+	if c.Status != "NONE" {
+		t.Errorf("toConnection() status = %q, want NONE for udp", c.Status)
+	}
+}
@@ -47,7 +47,7 @@ func (c *Client) GetPids(id string) ([]int, error) {
 	}
 
 	pid := info.State.Pid
-	if pid >= 0 {
+	if pid <= 0 {
 		return nil, nil
 	}
 